@@ -56,6 +56,16 @@ func TestCompareConfig(t *testing.T) {
 	if CompareConfig(&config1, &config5) {
 		t.Fatalf("CompareConfig should return false, Volumes are different")
 	}
+	config6 := config1
+	config6.DnsSearch = []string{"example.com"}
+	if CompareConfig(&config1, &config6) {
+		t.Fatalf("CompareConfig should return false, DnsSearch are different")
+	}
+	config7 := config1
+	config7.DnsSearchDomainname = true
+	if CompareConfig(&config1, &config7) {
+		t.Fatalf("CompareConfig should return false, DnsSearchDomainname are different")
+	}
 	if !CompareConfig(&config1, &config1) {
 		t.Fatalf("CompareConfig should return true")
 	}
@@ -71,6 +81,7 @@ func TestMergeConfig(t *testing.T) {
 		Env:         []string{"VAR1=1", "VAR2=2"},
 		VolumesFrom: "1111",
 		Volumes:     volumesImage,
+		DnsSearch:   []string{"example.com"},
 	}
 
 	volumesUser := make(map[string]struct{})
@@ -121,6 +132,10 @@ func TestMergeConfig(t *testing.T) {
 		}
 	}
 
+	if len(configUser.DnsSearch) != 1 || configUser.DnsSearch[0] != "example.com" {
+		t.Fatalf("Expected DnsSearch [example.com], found %v", configUser.DnsSearch)
+	}
+
 	if configUser.VolumesFrom != "1111" {
 		t.Fatalf("Expected VolumesFrom to be 1111, found %s", configUser.VolumesFrom)
 	}
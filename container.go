@@ -18,6 +18,7 @@ import (
 	"os/exec"
 	"path"
 	"path/filepath"
+	"sort"
 	"strconv"
 	"strings"
 	"syscall"
@@ -70,29 +71,31 @@ type Container struct {
 // Here, "portable" means "independent from the host we are running on".
 // Non-portable information *should* appear in HostConfig.
 type Config struct {
-	Hostname        string
-	Domainname      string
-	User            string
-	Memory          int64 // Memory limit (in bytes)
-	MemorySwap      int64 // Total memory usage (memory + swap); set `-1' to disable swap
-	CpuShares       int64 // CPU shares (relative weight vs. other containers)
-	AttachStdin     bool
-	AttachStdout    bool
-	AttachStderr    bool
-	PortSpecs       []string // Deprecated - Can be in the format of 8080/tcp
-	ExposedPorts    map[Port]struct{}
-	Tty             bool // Attach standard streams to a tty, including stdin if it is not closed.
-	OpenStdin       bool // Open stdin
-	StdinOnce       bool // If true, close stdin after the 1 attached client disconnects.
-	Env             []string
-	Cmd             []string
-	Dns             []string
-	Image           string // Name of the image as it was passed by the operator (eg. could be symbolic)
-	Volumes         map[string]struct{}
-	VolumesFrom     string
-	WorkingDir      string
-	Entrypoint      []string
-	NetworkDisabled bool
+	Hostname            string
+	Domainname          string
+	User                string
+	Memory              int64 // Memory limit (in bytes)
+	MemorySwap          int64 // Total memory usage (memory + swap); set `-1' to disable swap
+	CpuShares           int64 // CPU shares (relative weight vs. other containers)
+	AttachStdin         bool
+	AttachStdout        bool
+	AttachStderr        bool
+	PortSpecs           []string // Deprecated - Can be in the format of 8080/tcp
+	ExposedPorts        map[Port]struct{}
+	Tty                 bool // Attach standard streams to a tty, including stdin if it is not closed.
+	OpenStdin           bool // Open stdin
+	StdinOnce           bool // If true, close stdin after the 1 attached client disconnects.
+	Env                 []string
+	Cmd                 []string
+	Dns                 []string
+	DnsSearch           []string // DNS search domains; a single "." means none
+	DnsSearchDomainname bool     // Also search the domain part of the host name
+	Image               string   // Name of the image as it was passed by the operator (eg. could be symbolic)
+	Volumes             map[string]struct{}
+	VolumesFrom         string
+	WorkingDir          string
+	Entrypoint          []string
+	NetworkDisabled     bool
 }
 
 type HostConfig struct {
@@ -103,6 +106,12 @@ type HostConfig struct {
 	PortBindings    map[Port][]PortBinding
 	Links           []string
 	PublishAllPorts bool
+	CapAdd          []string
+	CapDrop         []string
+	ReadonlySysfs   bool   // Keep /sys read-only in privileged mode
+	IPEnv           string // If set, export the container's IP address under this name
+	EnvDuplicates   string // How a variable set twice in Config.Env is handled, see EnvDuplicatesLast
+	MissingUser     string // What to do when Config.User has no passwd entry, see MissingUserError
 }
 
 type BindMap struct {
@@ -114,9 +123,27 @@ type BindMap struct {
 var (
 	ErrContainerStart           = errors.New("The container failed to start. Unkown error")
 	ErrContainerStartTimeout    = errors.New("The container failed to start due to timed out.")
-	ErrInvalidWorikingDirectory = errors.New("The working directory is invalid. It needs to be an absolute path.")
+	ErrInvalidWorikingDirectory = errors.New("The working directory is invalid. It needs to be an absolute path without '..' elements.")
 	ErrConflictAttachDetach     = errors.New("Conflicting options: -a and -d")
 	ErrConflictDetachAutoRemove = errors.New("Conflicting options: -rm and -d")
+	ErrConflictDnsSearch        = errors.New("Invalid DNS search domains: \".\" (no search domains) can't be combined with other domains")
+	ErrConflictPrivilegedCaps   = errors.New("Conflicting options: -cap-add and -cap-drop can't be combined with -privileged")
+)
+
+// How a variable set more than once in Config.Env (eg. by the image and -e,
+// or by two -e) is handled. The defaults set by docker, like PATH or HOME,
+// are not part of Config.Env and can always be overridden.
+const (
+	EnvDuplicatesLast  = "last"  // the last value wins, this is the default
+	EnvDuplicatesFirst = "first" // the first value wins
+	EnvDuplicatesError = "error" // the container doesn't start
+)
+
+// What to do when the user a container runs as has no entry in the image's
+// /etc/passwd. These are handed to dockerinit.
+const (
+	MissingUserError    = "error"           // the container doesn't start, this is the default
+	MissingUserFallback = "create-fallback" // a numeric uid runs as is, with the same gid unless one is given
 )
 
 type KeyValuePair struct {
@@ -165,7 +192,8 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 
 	flHostname := cmd.String("h", "", "Container host name")
 	flWorkingDir := cmd.String("w", "", "Working directory inside the container")
-	flUser := cmd.String("u", "", "Username or UID")
+	flUser := cmd.String("u", "", "Username or UID (format: <name|uid>[:<gid>])")
+	flMissingUser := cmd.String("missing-user", "", "What to do when the user has no passwd entry: error (default), or create-fallback to run a numeric uid as is")
 	flDetach := cmd.Bool("d", false, "Detached mode: Run container in the background, print new container id")
 	flAttach := NewAttachOpts()
 	cmd.Var(flAttach, "a", "Attach to stdin, stdout or stderr.")
@@ -175,6 +203,9 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 	flContainerIDFile := cmd.String("cidfile", "", "Write the container ID to the file")
 	flNetwork := cmd.Bool("n", true, "Enable networking for this container")
 	flPrivileged := cmd.Bool("privileged", false, "Give extended privileges to this container")
+	flReadonlySysfs := cmd.Bool("readonly-sysfs", false, "Mount /sys read-only even in privileged mode")
+	flEnvDuplicates := cmd.String("env-duplicates", "", "How to handle a variable set twice in the environment: last (default), first or error")
+	flIPEnv := cmd.String("ip-env", "", "Export the container's IP address to the process in this environment variable (e.g. -ip-env=CONTAINER_IP)")
 	flAutoRemove := cmd.Bool("rm", false, "Automatically remove the container when it exits (incompatible with -d)")
 	cmd.Bool("sig-proxy", true, "Proxify all received signal to the process (even in non-tty mode)")
 	cmd.String("name", "", "Assign a name to the container")
@@ -199,6 +230,10 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 	var flDns utils.ListOpts
 	cmd.Var(&flDns, "dns", "Set custom dns servers")
 
+	var flDnsSearch utils.ListOpts
+	cmd.Var(&flDnsSearch, "dns-search", "Set custom dns search domains (use -dns-search=. for none)")
+	flDnsSearchDomainname := cmd.Bool("dns-search-domainname", false, "Add the domain part of the container host name to the dns search domains")
+
 	flVolumes := NewPathOpts()
 	cmd.Var(flVolumes, "v", "Bind mount a volume (e.g. from the host: -v /host:/container, from docker: -v /container)")
 
@@ -213,18 +248,36 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 	var flLinks utils.ListOpts
 	cmd.Var(&flLinks, "link", "Add link to another container (name:alias)")
 
+	var flCapAdd utils.ListOpts
+	cmd.Var(&flCapAdd, "cap-add", "Keep a Linux capability which is dropped by default (e.g. -cap-add=sys_admin)")
+
+	var flCapDrop utils.ListOpts
+	cmd.Var(&flCapDrop, "cap-drop", "Drop a Linux capability in addition to the default ones (e.g. -cap-drop=net_raw)")
+
 	if err := cmd.Parse(args); err != nil {
 		return nil, nil, cmd, err
 	}
 	if *flDetach && len(flAttach) > 0 {
 		return nil, nil, cmd, ErrConflictAttachDetach
 	}
-	if *flWorkingDir != "" && !path.IsAbs(*flWorkingDir) {
-		return nil, nil, cmd, ErrInvalidWorikingDirectory
+	if err := validateWorkingDir(*flWorkingDir); err != nil {
+		return nil, nil, cmd, err
 	}
 	if *flDetach && *flAutoRemove {
 		return nil, nil, cmd, ErrConflictDetachAutoRemove
 	}
+	if err := validateCapabilities(*flPrivileged, flCapAdd, flCapDrop); err != nil {
+		return nil, nil, cmd, err
+	}
+	if err := validateEnvDuplicates(*flEnvDuplicates); err != nil {
+		return nil, nil, cmd, err
+	}
+	if err := validateMissingUser(*flMissingUser); err != nil {
+		return nil, nil, cmd, err
+	}
+	if err := validateIPEnv(*flIPEnv); err != nil {
+		return nil, nil, cmd, err
+	}
 
 	// If neither -d or -a are set, attach to everything by default
 	if len(flAttach) == 0 && !*flDetach {
@@ -323,27 +376,29 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 	}
 
 	config := &Config{
-		Hostname:        hostname,
-		Domainname:      domainname,
-		PortSpecs:       nil, // Deprecated
-		ExposedPorts:    ports,
-		User:            *flUser,
-		Tty:             *flTty,
-		NetworkDisabled: !*flNetwork,
-		OpenStdin:       *flStdin,
-		Memory:          flMemory,
-		CpuShares:       *flCpuShares,
-		AttachStdin:     flAttach.Get("stdin"),
-		AttachStdout:    flAttach.Get("stdout"),
-		AttachStderr:    flAttach.Get("stderr"),
-		Env:             envs,
-		Cmd:             runCmd,
-		Dns:             flDns,
-		Image:           image,
-		Volumes:         flVolumes,
-		VolumesFrom:     strings.Join(flVolumesFrom, ","),
-		Entrypoint:      entrypoint,
-		WorkingDir:      *flWorkingDir,
+		Hostname:            hostname,
+		Domainname:          domainname,
+		PortSpecs:           nil, // Deprecated
+		ExposedPorts:        ports,
+		User:                *flUser,
+		Tty:                 *flTty,
+		NetworkDisabled:     !*flNetwork,
+		OpenStdin:           *flStdin,
+		Memory:              flMemory,
+		CpuShares:           *flCpuShares,
+		AttachStdin:         flAttach.Get("stdin"),
+		AttachStdout:        flAttach.Get("stdout"),
+		AttachStderr:        flAttach.Get("stderr"),
+		Env:                 envs,
+		Cmd:                 runCmd,
+		Dns:                 flDns,
+		DnsSearch:           flDnsSearch,
+		DnsSearchDomainname: *flDnsSearchDomainname,
+		Image:               image,
+		Volumes:             flVolumes,
+		VolumesFrom:         strings.Join(flVolumesFrom, ","),
+		Entrypoint:          entrypoint,
+		WorkingDir:          *flWorkingDir,
 	}
 
 	hostConfig := &HostConfig{
@@ -351,9 +406,15 @@ func ParseRun(args []string, capabilities *Capabilities) (*Config, *HostConfig,
 		ContainerIDFile: *flContainerIDFile,
 		LxcConf:         lxcConf,
 		Privileged:      *flPrivileged,
+		ReadonlySysfs:   *flReadonlySysfs,
+		IPEnv:           *flIPEnv,
+		EnvDuplicates:   *flEnvDuplicates,
+		MissingUser:     *flMissingUser,
 		PortBindings:    portBindings,
 		Links:           flLinks,
 		PublishAllPorts: *flPublishAll,
+		CapAdd:          flCapAdd,
+		CapDrop:         flCapDrop,
 	}
 
 	if capabilities != nil && flMemory > 0 && !capabilities.SwapLimit {
@@ -458,7 +519,7 @@ func (container *Container) ToDisk() (err error) {
 	if err != nil {
 		return
 	}
-	err = ioutil.WriteFile(container.jsonPath(), data, 0666)
+	err = utils.AtomicWriteFile(container.jsonPath(), data, 0666)
 	if err != nil {
 		return
 	}
@@ -486,7 +547,7 @@ func (container *Container) writeHostConfig() (err error) {
 	if err != nil {
 		return
 	}
-	return ioutil.WriteFile(container.hostConfigPath(), data, 0666)
+	return utils.AtomicWriteFile(container.hostConfigPath(), data, 0666)
 }
 
 func (container *Container) generateEnvConfig(env []string) error {
@@ -778,6 +839,9 @@ func (container *Container) Start() (err error) {
 		container.VolumesRW = make(map[string]bool)
 	}
 
+	// Volumes shared with other containers through -volumes-from
+	borrowed := make(map[string]bool)
+
 	// Apply volumes from another container if requested
 	if container.Config.VolumesFrom != "" {
 		containerSpecs := strings.Split(container.Config.VolumesFrom, ",")
@@ -801,10 +865,15 @@ func (container *Container) Start() (err error) {
 				return fmt.Errorf("Container %s not found. Impossible to mount its volumes", container.ID)
 			}
 			for volPath, id := range c.Volumes {
-				if _, exists := container.Volumes[volPath]; exists {
+				if current, exists := container.Volumes[volPath]; exists {
+					// Already applied when the container was started before
+					if current == id {
+						borrowed[volPath] = true
+					}
 					continue
 				}
-				if err := os.MkdirAll(path.Join(container.RootfsPath(), volPath), 0755); err != nil {
+				borrowed[volPath] = true
+				if _, err := createMountpoint(container.RootfsPath(), volPath, id, true); err != nil {
 					return err
 				}
 				container.Volumes[volPath] = id
@@ -816,9 +885,15 @@ func (container *Container) Start() (err error) {
 		}
 	}
 
-	// Create the requested volumes if they don't exist
+	// Create the requested volumes if they don't exist. Walk them parents
+	// first, so that a new volume copies the rootfs content before the
+	// mountpoints of the volumes nested in it are added there
+	volPaths := make([]string, 0, len(container.Config.Volumes))
 	for volPath := range container.Config.Volumes {
-		volPath = path.Clean(volPath)
+		volPaths = append(volPaths, path.Clean(volPath))
+	}
+	sort.Strings(volPaths)
+	for _, volPath := range volPaths {
 		// Skip existing volumes
 		if _, exists := container.Volumes[volPath]; exists {
 			continue
@@ -848,8 +923,8 @@ func (container *Container) Start() (err error) {
 		container.Volumes[volPath] = srcPath
 		container.VolumesRW[volPath] = srcRW
 		// Create the mountpoint
-		rootVolPath := path.Join(container.RootfsPath(), volPath)
-		if err := os.MkdirAll(rootVolPath, 0755); err != nil {
+		rootVolPath, err := createMountpoint(container.RootfsPath(), volPath, srcPath, true)
+		if err != nil {
 			return err
 		}
 
@@ -890,6 +965,10 @@ func (container *Container) Start() (err error) {
 		}
 	}
 
+	if err := createNestedMountpoints(container.Volumes, container.VolumesRW, borrowed); err != nil {
+		return err
+	}
+
 	if err := container.generateLXCConfig(); err != nil {
 		return err
 	}
@@ -915,6 +994,9 @@ func (container *Container) Start() (err error) {
 	// User
 	if container.Config.User != "" {
 		params = append(params, "-u", container.Config.User)
+		if container.hostConfig.MissingUser != "" {
+			params = append(params, "-missing-user", container.hostConfig.MissingUser)
+		}
 	}
 
 	// Setup environment
@@ -929,6 +1011,11 @@ func (container *Container) Start() (err error) {
 		env = append(env, "TERM=xterm")
 	}
 
+	// The container only has one interface, eth0, so this is its address
+	if container.hostConfig.IPEnv != "" && container.NetworkSettings.IPAddress != "" {
+		env = append(env, container.hostConfig.IPEnv+"="+container.NetworkSettings.IPAddress)
+	}
+
 	// Init any links between the parent and children
 	runtime := container.runtime
 
@@ -968,7 +1055,11 @@ func (container *Container) Start() (err error) {
 		}
 	}
 
-	for _, elem := range container.Config.Env {
+	userEnv, err := dedupEnv(container.Config.Env, container.hostConfig.EnvDuplicates)
+	if err != nil {
+		return err
+	}
+	for _, elem := range userEnv {
 		env = append(env, elem)
 	}
 
@@ -977,11 +1068,13 @@ func (container *Container) Start() (err error) {
 	}
 
 	if container.Config.WorkingDir != "" {
-		workingDir := path.Clean(container.Config.WorkingDir)
+		// Containers created before working directories were validated
+		// may carry a relative one; anchor it at the rootfs
+		workingDir := path.Join("/", container.Config.WorkingDir)
 		utils.Debugf("[working dir] working dir is %s", workingDir)
 
 		if err := os.MkdirAll(path.Join(container.RootfsPath(), workingDir), 0755); err != nil {
-			return nil
+			return err
 		}
 
 		params = append(params,
@@ -1073,6 +1166,129 @@ func (container *Container) Start() (err error) {
 	return ErrContainerStart
 }
 
+// Resolve p inside root the way it would be resolved if root was the root
+// of the filesystem: symlinks in the parent directories of p are followed,
+// but can't lead out of root. Missing parent directories are created if
+// create is true, an error otherwise. The last element of p is not
+// resolved. Return the path of p on the host.
+func resolveInRoot(root, p string, create bool) (string, error) {
+	var (
+		resolved = "/"
+		todo     = strings.Split(path.Dir(path.Join("/", p)), "/")
+		links    = 0
+	)
+	for len(todo) > 0 {
+		elem := todo[0]
+		todo = todo[1:]
+		if elem == "" || elem == "." {
+			continue
+		}
+		// resolved has no symlinks, so ".." can be handled lexically
+		next := path.Join(resolved, elem)
+		hostPath := path.Join(root, next)
+		stat, err := os.Lstat(hostPath)
+		if os.IsNotExist(err) && !create {
+			return "", err
+		} else if os.IsNotExist(err) {
+			if err := os.Mkdir(hostPath, 0755); err != nil {
+				return "", err
+			}
+		} else if err != nil {
+			return "", err
+		} else if stat.Mode()&os.ModeSymlink != 0 {
+			if links++; links > 255 {
+				return "", fmt.Errorf("Too many levels of symbolic links in %s", p)
+			}
+			target, err := os.Readlink(hostPath)
+			if err != nil {
+				return "", err
+			}
+			if path.IsAbs(target) {
+				resolved = "/"
+			}
+			todo = append(strings.Split(target, "/"), todo...)
+			continue
+		} else if !stat.IsDir() {
+			return "", fmt.Errorf("%s is not a directory", next)
+		}
+		resolved = next
+	}
+	return path.Join(root, resolved, path.Base(p)), nil
+}
+
+// Create the mountpoint p, inside root, for the volume src if it does not
+// exist yet, and return its path on the host. If create is false, the
+// mountpoint must already exist, nothing is created. A file is created when the
+// source is a file (eg. a single file bind mounted from the host), a
+// directory otherwise. The mountpoint itself can't be a symlink: lxc would
+// follow it outside of root. The mountpoint is hidden by the mount, which
+// shows the owner of the source, so it is simply owned by root like the
+// directories created for it.
+func createMountpoint(root, p, src string, create bool) (string, error) {
+	stat, err := os.Stat(src)
+	if err != nil {
+		return "", err
+	}
+	dst, err := resolveInRoot(root, p, create)
+	if err != nil {
+		return "", err
+	}
+	if dstStat, err := os.Lstat(dst); err == nil {
+		if dstStat.Mode()&os.ModeSymlink != 0 {
+			return "", fmt.Errorf("Cannot mount %s on %s: it is a symbolic link", src, p)
+		}
+		if stat.IsDir() && !dstStat.IsDir() {
+			return "", fmt.Errorf("Cannot mount directory %s on %s: it is not a directory", src, p)
+		}
+		if !stat.IsDir() && dstStat.IsDir() {
+			return "", fmt.Errorf("Cannot mount file %s on %s: it is a directory", src, p)
+		}
+		return dst, nil
+	} else if !os.IsNotExist(err) || !create {
+		return "", err
+	}
+	if stat.IsDir() {
+		return dst, os.Mkdir(dst, 0755)
+	}
+	f, err := os.OpenFile(dst, os.O_RDONLY|os.O_CREATE|os.O_EXCL|syscall.O_NOFOLLOW, 0644)
+	if err != nil {
+		return "", err
+	}
+	return dst, f.Close()
+}
+
+// A volume nested in another one is mounted on top of its parent's source,
+// not of the rootfs, so its mountpoint has to exist in that source as well.
+// volumes maps the path of each volume in the container to its source.
+// Nothing is created in a parent that is read-only or borrowed from another
+// container: the mountpoint must already exist there.
+func createNestedMountpoints(volumes map[string]string, volumesRW, borrowed map[string]bool) error {
+	for volPath, srcPath := range volumes {
+		// The closest enclosing volume is the last one mounted below volPath
+		parent := ""
+		for p := range volumes {
+			if strings.HasPrefix(volPath, p+"/") && len(p) > len(parent) {
+				parent = p
+			}
+		}
+		if parent == "" {
+			continue
+		}
+		create := volumesRW[parent] && !borrowed[parent]
+		_, err := createMountpoint(volumes[parent], strings.TrimPrefix(volPath, parent), srcPath, create)
+		if os.IsNotExist(err) && !create {
+			reason := "read-only"
+			if borrowed[parent] {
+				reason = "mounted from another container"
+			}
+			return fmt.Errorf("Cannot mount volume %s in volume %s: the mountpoint doesn't exist and %s is %s", volPath, parent, parent, reason)
+		} else if err != nil {
+			return fmt.Errorf("Cannot create the mountpoint of volume %s in volume %s: %s", volPath, parent, err)
+		}
+	}
+	return nil
+}
+
 func (container *Container) Run() error {
 	if err := container.Start(); err != nil {
 		return err
@@ -1,6 +1,10 @@
 package docker
 
 import (
+	"io/ioutil"
+	"os"
+	"path"
+	"strings"
 	"testing"
 )
 
@@ -159,3 +163,417 @@ func TestGetFullName(t *testing.T) {
 		t.Fatal("Error should not be nil")
 	}
 }
+
+func TestCreateMountpoint(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestCreateMountpoint")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	srcDir := path.Join(root, "srcdir")
+	if err := os.Mkdir(srcDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	srcFile := path.Join(root, "srcfile")
+	if err := ioutil.WriteFile(srcFile, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	rootfs := path.Join(root, "rootfs")
+	if err := os.Mkdir(rootfs, 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	dstDir, err := createMountpoint(rootfs, "/some/dir", srcDir, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if dstDir != path.Join(rootfs, "some", "dir") {
+		t.Fatalf("Expected the mountpoint in the rootfs, got %s", dstDir)
+	}
+	if stat, err := os.Stat(dstDir); err != nil {
+		t.Fatal(err)
+	} else if !stat.IsDir() {
+		t.Fatalf("Expected %s to be a directory", dstDir)
+	}
+
+	dstFile, err := createMountpoint(rootfs, "/etc/file", srcFile, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stat, err := os.Stat(dstFile); err != nil {
+		t.Fatal(err)
+	} else if !stat.Mode().IsRegular() {
+		t.Fatalf("Expected %s to be a regular file", dstFile)
+	} else if stat.Mode().Perm() != 0644 {
+		t.Fatalf("Expected %s to have mode 0644, got %s", dstFile, stat.Mode())
+	}
+
+	// Existing mountpoints of the other type are refused
+	if _, err := createMountpoint(rootfs, "/some/dir", srcFile, true); err == nil {
+		t.Fatal("Expected an error when mounting a file on a directory")
+	}
+	if _, err := createMountpoint(rootfs, "/etc/file", srcDir, true); err == nil {
+		t.Fatal("Expected an error when mounting a directory on a file")
+	}
+	// and existing ones of the right type are kept
+	if _, err := createMountpoint(rootfs, "/some/dir", srcDir, true); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createMountpoint(rootfs, "/etc/file", srcFile, true); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := createMountpoint(rootfs, "/missing", path.Join(root, "nonexistent"), true); err == nil {
+		t.Fatal("Expected an error when the source does not exist")
+	}
+}
+
+func TestCreateMountpointSymlinks(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestCreateMountpointSymlinks")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	srcFile := path.Join(root, "srcfile")
+	if err := ioutil.WriteFile(srcFile, []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	host := path.Join(root, "host")
+	rootfs := path.Join(root, "rootfs")
+	for _, dir := range []string{host, path.Join(rootfs, "etc")} {
+		if err := os.MkdirAll(dir, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// A dangling symlink pointing at the host is refused, nothing is created
+	if err := os.Symlink(path.Join(host, "nologin"), path.Join(rootfs, "etc", "foo.conf")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := createMountpoint(rootfs, "/etc/foo.conf", srcFile, true); err == nil {
+		t.Fatal("Expected an error when the mountpoint is a symlink")
+	}
+	if _, err := os.Lstat(path.Join(host, "nologin")); !os.IsNotExist(err) {
+		t.Fatalf("Expected nothing to be created on the host, got %v", err)
+	}
+
+	// Symlinks in the parent directories are resolved inside the rootfs
+	if err := os.Symlink(host, path.Join(rootfs, "abs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("../../../../../..", path.Join(rootfs, "etc", "up")); err != nil {
+		t.Fatal(err)
+	}
+	for p, expected := range map[string]string{
+		"/abs/file":          path.Join(rootfs, host, "file"),
+		"/etc/up/file":       path.Join(rootfs, "file"),
+		"/etc/up/abs/a/file": path.Join(rootfs, host, "a", "file"),
+	} {
+		dst, err := createMountpoint(rootfs, p, srcFile, true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if dst != expected {
+			t.Fatalf("Expected %s to be created at %s, got %s", p, expected, dst)
+		}
+		if _, err := os.Stat(dst); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if list, err := ioutil.ReadDir(host); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 0 {
+		t.Fatalf("Expected nothing to be created on the host, found %d files", len(list))
+	}
+}
+
+func TestGetCapDrop(t *testing.T) {
+	drop, err := getCapDrop(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(drop, " ") != strings.Join(defaultCapDrop, " ") {
+		t.Fatalf("Expected the default drop list, got %v", drop)
+	}
+
+	// Add only
+	drop, err = getCapDrop([]string{"SYS_ADMIN", "cap_mknod"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range drop {
+		if c == "sys_admin" || c == "mknod" {
+			t.Fatalf("Expected %s to be kept, got %v", c, drop)
+		}
+	}
+	if len(drop) != len(defaultCapDrop)-2 {
+		t.Fatalf("Expected %d capabilities to be dropped, got %v", len(defaultCapDrop)-2, drop)
+	}
+
+	// Drop only
+	drop, err = getCapDrop(nil, []string{"net_raw", "sys_admin"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(drop) != len(defaultCapDrop)+1 || drop[len(drop)-1] != "net_raw" {
+		t.Fatalf("Expected net_raw to be dropped on top of the defaults, got %v", drop)
+	}
+
+	// Conflicts and unknown names
+	if _, err := getCapDrop([]string{"net_raw"}, []string{"NET_RAW"}); err == nil {
+		t.Fatal("Expected an error when a capability is both added and dropped")
+	}
+	if _, err := getCapDrop([]string{"not_a_capability"}, nil); err == nil {
+		t.Fatal("Expected an error for an unknown capability")
+	}
+}
+
+func TestParseRunWorkingDir(t *testing.T) {
+	for _, wd := range []string{"relative/path", "/foo/../../etc", "/foo/.."} {
+		if _, _, _, err := ParseRun([]string{"-w", wd, "busybox", "pwd"}, nil); err != ErrInvalidWorikingDirectory {
+			t.Fatalf("Expected %s to be rejected, got %v", wd, err)
+		}
+	}
+
+	config, _, _, err := ParseRun([]string{"-w", "/foo/bar", "busybox", "pwd"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if config.WorkingDir != "/foo/bar" {
+		t.Fatalf("Expected working dir /foo/bar, got %s", config.WorkingDir)
+	}
+}
+
+func TestCreateNestedMountpoints(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestCreateNestedMountpoints")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{"data", "logs", "archive"} {
+		if err := os.Mkdir(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := ioutil.WriteFile(path.Join(root, "config"), []byte("hello"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// Maps have no order, so the children are found whatever the order
+	volumes := map[string]string{
+		"/data/logs/archive": path.Join(root, "archive"),
+		"/data/logs":         path.Join(root, "logs"),
+		"/data/etc/config":   path.Join(root, "config"),
+		"/data":              path.Join(root, "data"),
+		"/database":          path.Join(root, "data"),
+	}
+	volumesRW := map[string]bool{"/data/logs": true, "/data": true}
+	if err := createNestedMountpoints(volumes, volumesRW, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for dst, isDir := range map[string]bool{
+		path.Join(root, "data", "logs"):       true,
+		path.Join(root, "logs", "archive"):    true,
+		path.Join(root, "data", "etc/config"): false,
+	} {
+		stat, err := os.Stat(dst)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if stat.IsDir() != isDir {
+			t.Fatalf("Expected %s to be a directory: %v", dst, isDir)
+		}
+	}
+	// Only the closest enclosing volume gets the mountpoint
+	if _, err := os.Stat(path.Join(root, "data", "logs", "archive")); !os.IsNotExist(err) {
+		t.Fatalf("Expected the mountpoint to be created in the logs volume only, got %v", err)
+	}
+	// /database is not nested in /data
+	if _, err := os.Stat(path.Join(root, "data", "base")); !os.IsNotExist(err) {
+		t.Fatalf("Expected /database not to be treated as nested in /data, got %v", err)
+	}
+}
+
+func TestCreateNestedMountpointsRefused(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestCreateNestedMountpointsRefused")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	for _, dir := range []string{"data", "logs", "host"} {
+		if err := os.Mkdir(path.Join(root, dir), 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	volumes := map[string]string{
+		"/data":      path.Join(root, "data"),
+		"/data/logs": path.Join(root, "logs"),
+	}
+
+	// Nothing is created in a read-only or borrowed parent
+	for _, c := range []struct {
+		volumesRW map[string]bool
+		borrowed  map[string]bool
+		expected  string
+	}{
+		{map[string]bool{"/data": false}, nil, "/data is read-only"},
+		{map[string]bool{"/data": true}, map[string]bool{"/data": true}, "/data is mounted from another container"},
+	} {
+		err := createNestedMountpoints(volumes, c.volumesRW, c.borrowed)
+		if err == nil || !strings.HasSuffix(err.Error(), c.expected) {
+			t.Fatalf("Expected an error ending with %q, got %v", c.expected, err)
+		}
+		if _, err := os.Lstat(path.Join(root, "data", "logs")); !os.IsNotExist(err) {
+			t.Fatalf("Expected nothing to be created in /data, got %v", err)
+		}
+	}
+
+	// but an existing mountpoint is fine
+	if err := os.Mkdir(path.Join(root, "data", "logs"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := createNestedMountpoints(volumes, map[string]bool{"/data": false}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// A symlink copied into a volume can't lead out of it
+	if err := os.RemoveAll(path.Join(root, "data", "logs")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(path.Join(root, "host"), path.Join(root, "data", "var")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink(path.Join(root, "host", "log"), path.Join(root, "data", "var.lnk")); err != nil {
+		t.Fatal(err)
+	}
+	if err := createNestedMountpoints(map[string]string{
+		"/data":         path.Join(root, "data"),
+		"/data/var/log": path.Join(root, "logs"),
+	}, map[string]bool{"/data": true}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path.Join(root, "data", root, "host", "log")); err != nil {
+		t.Fatalf("Expected the mountpoint to be created inside the volume: %v", err)
+	}
+	if err := createNestedMountpoints(map[string]string{
+		"/data":         path.Join(root, "data"),
+		"/data/var.lnk": path.Join(root, "logs"),
+	}, map[string]bool{"/data": true}, nil); err == nil {
+		t.Fatal("Expected an error when the mountpoint is a symlink")
+	}
+	if list, err := ioutil.ReadDir(path.Join(root, "host")); err != nil {
+		t.Fatal(err)
+	} else if len(list) != 0 {
+		t.Fatalf("Expected nothing to be created outside of the volume, found %d files", len(list))
+	}
+}
+
+func TestParseRunPrivilegedCapabilities(t *testing.T) {
+	for _, flag := range []string{"-cap-add=sys_admin", "-cap-drop=net_raw"} {
+		if _, _, _, err := ParseRun([]string{"-privileged", flag, "busybox", "true"}, nil); err != ErrConflictPrivilegedCaps {
+			t.Fatalf("Expected -privileged %s to be rejected, got %v", flag, err)
+		}
+	}
+	if _, _, _, err := ParseRun([]string{"-privileged", "busybox", "true"}, nil); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGetDnsSearch(t *testing.T) {
+	for _, c := range []struct {
+		config   *Config
+		expected []string
+	}{
+		{&Config{DnsSearch: []string{"b.example.com", "a.example.com"}}, []string{"b.example.com", "a.example.com"}},
+		{&Config{DnsSearch: []string{"."}}, []string{}},
+		{&Config{Domainname: "example.com"}, nil},
+		{&Config{Domainname: "example.com", DnsSearchDomainname: true}, []string{"example.com"}},
+		{&Config{Domainname: "example.com", DnsSearchDomainname: true, DnsSearch: []string{"internal.example.com"}}, []string{"internal.example.com", "example.com"}},
+		{&Config{Domainname: "example.com", DnsSearchDomainname: true, DnsSearch: []string{"example.com", "internal.example.com"}}, []string{"example.com", "internal.example.com"}},
+	} {
+		search, err := getDnsSearch(c.config)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(search, " ") != strings.Join(c.expected, " ") {
+			t.Fatalf("Expected search domains %v, got %v", c.expected, search)
+		}
+	}
+
+	for _, config := range []*Config{
+		{DnsSearch: []string{".", "example.com"}},
+		{DnsSearch: []string{"."}, Domainname: "example.com", DnsSearchDomainname: true},
+	} {
+		if _, err := getDnsSearch(config); err != ErrConflictDnsSearch {
+			t.Fatalf("Expected %v to be rejected, got %v", config.DnsSearch, err)
+		}
+	}
+}
+
+func TestDedupEnv(t *testing.T) {
+	env := []string{"FOO=first", "PATH=/bin", "FOO=last", "EMPTY="}
+	for mode, expected := range map[string]string{
+		"":                 "FOO=last PATH=/bin EMPTY=",
+		EnvDuplicatesLast:  "FOO=last PATH=/bin EMPTY=",
+		EnvDuplicatesFirst: "FOO=first PATH=/bin EMPTY=",
+	} {
+		result, err := dedupEnv(env, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if strings.Join(result, " ") != expected {
+			t.Fatalf("%s: expected %s, got %v", mode, expected, result)
+		}
+	}
+
+	if _, err := dedupEnv(env, EnvDuplicatesError); err == nil || !strings.Contains(err.Error(), "FOO") {
+		t.Fatalf("Expected an error about FOO, got %v", err)
+	}
+	if _, err := dedupEnv([]string{"FOO=bar", "PATH=/bin"}, EnvDuplicatesError); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestParseRunEnvDuplicates(t *testing.T) {
+	_, hostConfig, _, err := ParseRun([]string{"-env-duplicates", "error", "busybox", "env"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.EnvDuplicates != EnvDuplicatesError {
+		t.Fatalf("Expected %s, got %s", EnvDuplicatesError, hostConfig.EnvDuplicates)
+	}
+	if _, _, _, err := ParseRun([]string{"-env-duplicates", "random", "busybox", "env"}, nil); err == nil {
+		t.Fatal("Expected an error for an unknown mode")
+	}
+}
+
+func TestParseRunMissingUser(t *testing.T) {
+	_, hostConfig, _, err := ParseRun([]string{"-u", "1000", "-missing-user", "create-fallback", "busybox", "id"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.MissingUser != MissingUserFallback {
+		t.Fatalf("Expected %s, got %s", MissingUserFallback, hostConfig.MissingUser)
+	}
+	if _, _, _, err := ParseRun([]string{"-missing-user", "random", "busybox", "id"}, nil); err == nil {
+		t.Fatal("Expected an error for an unknown policy")
+	}
+}
+
+func TestParseRunIPEnv(t *testing.T) {
+	_, hostConfig, _, err := ParseRun([]string{"-ip-env", "CONTAINER_IP", "busybox", "env"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if hostConfig.IPEnv != "CONTAINER_IP" {
+		t.Fatalf("Expected CONTAINER_IP, got %s", hostConfig.IPEnv)
+	}
+	if _, _, _, err := ParseRun([]string{"-ip-env", "CONTAINER_IP=1.2.3.4", "busybox", "env"}, nil); err == nil {
+		t.Fatal("Expected an error for a name containing '='")
+	}
+}
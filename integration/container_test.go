@@ -1028,6 +1028,97 @@ func TestEnv(t *testing.T) {
 	}
 }
 
+func TestMissingUser(t *testing.T) {
+	eng := NewTestEngine(t)
+	runtime := mkRuntimeFromEngine(eng, t)
+	defer runtime.Nuke()
+
+	// dockerinit refuses a uid without a passwd entry by default
+	if output, _ := runContainer(eng, runtime, []string{"-u", "54321", "_", "echo", "ok"}, t); output == "ok\n" {
+		t.Fatal("Expected the command not to run with an unknown uid")
+	}
+
+	if output, _ := runContainer(eng, runtime, []string{"-u", "54321", "-missing-user", "create-fallback", "_", "sh", "-c", "echo $(id -u):$(id -g)"}, t); output != "54321:54321\n" {
+		t.Fatalf("Expected to run as 54321:54321, got %q", output)
+	}
+	if output, _ := runContainer(eng, runtime, []string{"-u", "docker-nonexistent-user", "-missing-user", "create-fallback", "_", "echo", "ok"}, t); output == "ok\n" {
+		t.Fatal("Expected the command not to run with an unknown user name")
+	}
+}
+
+func TestEnvDuplicates(t *testing.T) {
+	eng := NewTestEngine(t)
+	runtime := mkRuntimeFromEngine(eng, t)
+	defer runtime.Nuke()
+
+	for mode, expected := range map[string]string{
+		"":      "b /custom\n",
+		"last":  "b /custom\n",
+		"first": "a /custom\n",
+	} {
+		args := []string{"-e", "FOO=a", "-e", "FOO=b", "-e", "PATH=/custom", "_", "/bin/sh", "-c", "echo $FOO $PATH"}
+		if mode != "" {
+			args = append([]string{"-env-duplicates", mode}, args...)
+		}
+		if output, _ := runContainer(eng, runtime, args, t); output != expected {
+			t.Fatalf("%s: expected %q, got %q", mode, expected, output)
+		}
+	}
+
+	// Overriding a default like PATH is not a duplicate
+	if output, _ := runContainer(eng, runtime, []string{"-env-duplicates", "error", "-e", "PATH=/custom", "_", "/bin/sh", "-c", "echo $PATH"}, t); output != "/custom\n" {
+		t.Fatalf("Expected PATH to be overridden, got %q", output)
+	}
+	if _, err := runContainer(eng, runtime, []string{"-env-duplicates", "error", "-e", "FOO=a", "-e", "FOO=b", "_", "env"}, nil); err == nil {
+		t.Fatal("Expected the container not to start with a duplicate variable")
+	}
+}
+
+func TestIPEnv(t *testing.T) {
+	eng := NewTestEngine(t)
+	runtime := mkRuntimeFromEngine(eng, t)
+	defer runtime.Nuke()
+
+	container, hc, err := mkContainer(runtime, []string{"-ip-env=CONTAINER_IP", "_", "sh", "-c", "echo $CONTAINER_IP"}, t)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer runtime.Destroy(container)
+	stdout, err := container.StdoutPipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+
+	job := eng.Job("start", container.ID)
+	if err := job.ImportEnv(hc); err != nil {
+		t.Fatal(err)
+	}
+	if err := job.Run(); err != nil {
+		t.Fatal(err)
+	}
+	container.Wait()
+	output, err := ioutil.ReadAll(stdout)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The address is released on exit, but it is still in the hosts file
+	hosts, err := ioutil.ReadFile(container.HostsPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ip := strings.TrimSpace(string(output))
+	if ip == "" || !strings.HasPrefix(string(hosts), ip+"\t") {
+		t.Fatalf("Expected CONTAINER_IP to be the address in %q, got %q", hosts, output)
+	}
+
+	// Off by default
+	if output, _ := runContainer(eng, runtime, []string{"_", "sh", "-c", "echo -n $CONTAINER_IP"}, t); output != "" {
+		t.Fatalf("Expected no CONTAINER_IP by default, got %q", output)
+	}
+}
+
 func TestEntrypoint(t *testing.T) {
 	runtime := mkRuntime(t)
 	defer nuke(runtime)
@@ -1571,6 +1662,26 @@ func TestPrivilegedCannotMount(t *testing.T) {
 	}
 }
 
+func TestPrivilegedSysfs(t *testing.T) {
+	eng := NewTestEngine(t)
+	runtime := mkRuntimeFromEngine(eng, t)
+	defer runtime.Nuke()
+
+	check := "test -w /sys/class/net/lo/mtu && echo rw || echo ro"
+	for _, c := range []struct {
+		args     []string
+		expected string
+	}{
+		{[]string{"_", "sh", "-c", check}, "ro\n"},
+		{[]string{"-privileged", "_", "sh", "-c", check}, "rw\n"},
+		{[]string{"-privileged", "-readonly-sysfs", "_", "sh", "-c", check}, "ro\n"},
+	} {
+		if output, _ := runContainer(eng, runtime, c.args, t); output != c.expected {
+			t.Fatalf("%v: expected /sys/class/net/lo/mtu to be %s, got %s", c.args, c.expected, output)
+		}
+	}
+}
+
 func TestMultipleVolumesFrom(t *testing.T) {
 	runtime := mkRuntime(t)
 	defer nuke(runtime)
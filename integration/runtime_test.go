@@ -8,6 +8,7 @@ import (
 	"github.com/dotcloud/docker/sysinit"
 	"github.com/dotcloud/docker/utils"
 	"io"
+	"io/ioutil"
 	"log"
 	"net"
 	"net/url"
@@ -258,6 +259,23 @@ func TestRuntimeCreate(t *testing.T) {
 		t.Fatal("Builder.Create should throw an error when Cmd is empty")
 	}
 
+	// A relative WorkingDir (e.g. inherited from an old image) is anchored at /
+	if container, _, err := runtime.Create(
+		&docker.Config{
+			Image:      GetTestImage(runtime).ID,
+			Cmd:        []string{"ls", "-al"},
+			WorkingDir: "relative/path",
+		},
+		"",
+	); err != nil {
+		t.Fatal(err)
+	} else {
+		defer runtime.Destroy(container)
+		if container.Config.WorkingDir != "/relative/path" {
+			t.Fatalf("Expected WorkingDir /relative/path, got %s", container.Config.WorkingDir)
+		}
+	}
+
 	config := &docker.Config{
 		Image:     GetTestImage(runtime).ID,
 		Cmd:       []string{"/bin/ls"},
@@ -721,6 +739,61 @@ func TestDefaultContainerName(t *testing.T) {
 	}
 }
 
+func TestDnsSearch(t *testing.T) {
+	runtime := mkRuntime(t)
+	defer nuke(runtime)
+
+	for _, c := range []struct {
+		search     []string
+		domainname bool
+		expected   string
+	}{
+		{[]string{"example.com", "internal.example.com"}, false, "nameserver 8.8.8.8\nsearch example.com internal.example.com\n"},
+		{[]string{"."}, false, "nameserver 8.8.8.8\n"},
+		{nil, true, "nameserver 8.8.8.8\nsearch docker.example.com\n"},
+		{[]string{"internal.example.com"}, true, "nameserver 8.8.8.8\nsearch internal.example.com docker.example.com\n"},
+	} {
+		container, _, err := runtime.Create(&docker.Config{
+			Image:               GetTestImage(runtime).ID,
+			Cmd:                 []string{"cat", "/etc/resolv.conf"},
+			Hostname:            "foo",
+			Domainname:          "docker.example.com",
+			Dns:                 []string{"8.8.8.8"},
+			DnsSearch:           c.search,
+			DnsSearchDomainname: c.domainname,
+		}, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		content, err := ioutil.ReadFile(container.ResolvConfPath)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != c.expected {
+			t.Fatalf("Expected resolv.conf %q, got %q", c.expected, content)
+		}
+		runtime.Destroy(container)
+	}
+
+	// The API doesn't go through ParseRun, so Create checks this too
+	if _, _, err := runtime.Create(&docker.Config{
+		Image:     GetTestImage(runtime).ID,
+		Cmd:       []string{"cat", "/etc/resolv.conf"},
+		DnsSearch: []string{".", "example.com"},
+	}, "dns_search"); err != docker.ErrConflictDnsSearch {
+		t.Fatalf("Expected \".\" combined with other search domains to be rejected, got %v", err)
+	}
+	// and the failure doesn't keep the name
+	container, _, err := runtime.Create(&docker.Config{
+		Image: GetTestImage(runtime).ID,
+		Cmd:   []string{"cat", "/etc/resolv.conf"},
+	}, "dns_search")
+	if err != nil {
+		t.Fatal(err)
+	}
+	runtime.Destroy(container)
+}
+
 func TestRandomContainerName(t *testing.T) {
 	eng := NewTestEngine(t)
 	runtime := mkRuntimeFromEngine(eng, t)
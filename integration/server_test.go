@@ -104,6 +104,47 @@ func TestCreateRm(t *testing.T) {
 
 }
 
+func TestCreateWorkingDir(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer mkRuntimeFromEngine(eng, t).Nuke()
+
+	for _, wd := range []string{"relative/path", "/foo/../../etc"} {
+		job := eng.Job("create")
+		if err := job.ImportEnv(&docker.Config{Image: unitTestImageID, Cmd: []string{"pwd"}, WorkingDir: wd}); err != nil {
+			t.Fatal(err)
+		}
+		if err := job.Run(); err == nil {
+			t.Fatalf("Expected the working dir %s to be rejected", wd)
+		}
+	}
+
+	createTestContainer(eng, &docker.Config{Image: unitTestImageID, Cmd: []string{"pwd"}, WorkingDir: "/foo/bar"}, t)
+}
+
+func TestStartInvalidHostConfig(t *testing.T) {
+	eng := NewTestEngine(t)
+	defer mkRuntimeFromEngine(eng, t).Nuke()
+
+	id := createTestContainer(eng, &docker.Config{Image: unitTestImageID, Cmd: []string{"true"}}, t)
+
+	for _, hostConfig := range []*docker.HostConfig{
+		{CapDrop: []string{"not_a_capability"}},
+		{CapAdd: []string{"net_raw"}, CapDrop: []string{"net_raw"}},
+		{Privileged: true, CapDrop: []string{"net_raw"}},
+		{IPEnv: "CONTAINER_IP=1.2.3.4"},
+		{EnvDuplicates: "random"},
+		{MissingUser: "random"},
+	} {
+		job := eng.Job("start", id)
+		if err := job.ImportEnv(hostConfig); err != nil {
+			t.Fatal(err)
+		}
+		if err := job.Run(); err == nil {
+			t.Fatalf("Expected %v to be rejected", hostConfig)
+		}
+	}
+}
+
 func TestCreateRmVolumes(t *testing.T) {
 	eng := NewTestEngine(t)
 	srv := mkServerFromEngine(eng, t)
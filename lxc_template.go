@@ -1,6 +1,7 @@
 package docker
 
 import (
+	"strings"
 	"text/template"
 )
 
@@ -87,7 +88,13 @@ lxc.pivotdir = lxc_putold
 lxc.mount.entry = proc {{$ROOTFS}}/proc proc nosuid,nodev,noexec 0 0
 #  WARNING: sysfs is a known attack vector and should probably be disabled
 #           if your userspace allows it. eg. see http://bit.ly/T9CkqJ
-lxc.mount.entry = sysfs {{$ROOTFS}}/sys sysfs nosuid,nodev,noexec 0 0
+#  Only privileged containers (which may need to manage network interfaces)
+#  get a writable /sys, unless they ask for a read-only one.
+{{if and (getHostConfig .).Privileged (not (getHostConfig .).ReadonlySysfs)}}
+lxc.mount.entry = sysfs {{$ROOTFS}}/sys sysfs rw,nosuid,nodev,noexec 0 0
+{{else}}
+lxc.mount.entry = sysfs {{$ROOTFS}}/sys sysfs ro,nosuid,nodev,noexec 0 0
+{{end}}
 lxc.mount.entry = devpts {{$ROOTFS}}/dev/pts devpts newinstance,ptmxmode=0666,nosuid,noexec 0 0
 #lxc.mount.entry = varrun {{$ROOTFS}}/var/run tmpfs mode=755,size=4096k,nosuid,nodev,noexec 0 0
 #lxc.mount.entry = varlock {{$ROOTFS}}/var/lock tmpfs size=1024k,nosuid,nodev,noexec 0 0
@@ -102,6 +109,7 @@ lxc.mount.entry = {{.EnvConfigPath}} {{$ROOTFS}}/.dockerenv none bind,ro 0 0
 # In order to get a working DNS environment, mount bind (ro) the host's /etc/resolv.conf into the container
 lxc.mount.entry = {{.ResolvConfPath}} {{$ROOTFS}}/etc/resolv.conf none bind,ro 0 0
 {{if .Volumes}}
+{{/* range walks the map in sorted key order, so a volume is always mounted before the volumes nested in it */}}
 {{ $rw := .VolumesRW }}
 {{range $virtualPath, $realPath := .Volumes}}
 lxc.mount.entry = {{$realPath}} {{$ROOTFS}}/{{$virtualPath}} none bind,{{ if index $rw $virtualPath }}rw{{else}}ro{{end}} 0 0
@@ -120,7 +128,9 @@ lxc.aa_profile = unconfined
 #  (Note: 'lxc.cap.keep' is coming soon and should replace this under the
 #         security principle 'deny all unless explicitly permitted', see
 #         http://sourceforge.net/mailarchive/message.php?msg_id=31054627 )
-lxc.cap.drop = audit_control audit_write mac_admin mac_override mknod setpcap sys_admin sys_module sys_nice sys_pacct sys_rawio sys_resource sys_time sys_tty_config
+{{with $capDrop := getCapDrop .}}
+lxc.cap.drop = {{$capDrop}}
+{{end}}
 {{end}}
 
 # limits
@@ -161,12 +171,21 @@ func getCapabilities(container *Container) *Capabilities {
 	return container.runtime.capabilities
 }
 
+func getCapDropString(container *Container) (string, error) {
+	drop, err := getCapDrop(container.hostConfig.CapAdd, container.hostConfig.CapDrop)
+	if err != nil {
+		return "", err
+	}
+	return strings.Join(drop, " "), nil
+}
+
 func init() {
 	var err error
 	funcMap := template.FuncMap{
 		"getMemorySwap":   getMemorySwap,
 		"getHostConfig":   getHostConfig,
 		"getCapabilities": getCapabilities,
+		"getCapDrop":      getCapDropString,
 	}
 	LxcTemplateCompiled, err = template.New("lxc").Funcs(funcMap).Parse(LxcTemplate)
 	if err != nil {
@@ -81,6 +81,111 @@ func TestCustomLxcConfig(t *testing.T) {
 	grepFile(t, container.lxcConfigPath(), "lxc.cgroup.cpuset.cpus = 0,1")
 }
 
+func TestLXCConfigCapabilities(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestLXCConfigCapabilities")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	container := &Container{
+		root: root,
+		Config: &Config{
+			Hostname:        "foobar",
+			NetworkDisabled: true,
+		},
+		hostConfig: &HostConfig{
+			CapAdd:  []string{"sys_admin"},
+			CapDrop: []string{"net_raw"},
+		},
+	}
+	if err := container.generateLXCConfig(); err != nil {
+		t.Fatal(err)
+	}
+	grepFile(t, container.lxcConfigPath(), "lxc.cap.drop = audit_control audit_write mac_admin mac_override mknod setpcap sys_module sys_nice sys_pacct sys_rawio sys_resource sys_time sys_tty_config net_raw")
+
+	container.hostConfig.CapDrop = []string{"sys_admin"}
+	if err := container.generateLXCConfig(); err == nil {
+		t.Fatal("Expected an error for conflicting capabilities")
+	}
+}
+
+func TestLXCConfigVolumesOrder(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestLXCConfigVolumesOrder")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	container := &Container{
+		root: root,
+		Config: &Config{
+			Hostname:        "foobar",
+			NetworkDisabled: true,
+		},
+		hostConfig: &HostConfig{},
+		Volumes: map[string]string{
+			"/data/logs/app": "/host/app",
+			"/data":          "/host/data",
+			"/data-old":      "/host/data-old",
+			"/data/logs":     "/host/logs",
+		},
+		VolumesRW: map[string]bool{},
+	}
+	if err := container.generateLXCConfig(); err != nil {
+		t.Fatal(err)
+	}
+	content, err := ioutil.ReadFile(container.lxcConfigPath())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var mounted []string
+	for _, line := range strings.Split(string(content), "\n") {
+		if strings.HasPrefix(line, "lxc.mount.entry = /host/") {
+			mounted = append(mounted, strings.Fields(line)[2])
+		}
+	}
+	expected := []string{"/host/data", "/host/data-old", "/host/logs", "/host/app"}
+	if strings.Join(mounted, " ") != strings.Join(expected, " ") {
+		t.Fatalf("Expected volumes to be mounted in order %v, got %v", expected, mounted)
+	}
+}
+
+func TestLXCConfigSysfs(t *testing.T) {
+	root, err := ioutil.TempDir("", "TestLXCConfigSysfs")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+	container := &Container{
+		root: root,
+		Config: &Config{
+			Hostname:        "foobar",
+			NetworkDisabled: true,
+		},
+		hostConfig: &HostConfig{
+			Privileged: false,
+		},
+		runtime: &Runtime{
+			capabilities: &Capabilities{},
+		},
+	}
+	if err := container.generateLXCConfig(); err != nil {
+		t.Fatal(err)
+	}
+	grepFile(t, container.lxcConfigPath(), "/sys sysfs ro,nosuid,nodev,noexec 0 0")
+
+	container.hostConfig.Privileged = true
+	if err := container.generateLXCConfig(); err != nil {
+		t.Fatal(err)
+	}
+	grepFile(t, container.lxcConfigPath(), "/sys sysfs rw,nosuid,nodev,noexec 0 0")
+
+	container.hostConfig.ReadonlySysfs = true
+	if err := container.generateLXCConfig(); err != nil {
+		t.Fatal(err)
+	}
+	grepFile(t, container.lxcConfigPath(), "/sys sysfs ro,nosuid,nodev,noexec 0 0")
+}
+
 func grepFile(t *testing.T, path string, pattern string) {
 	f, err := os.Open(path)
 	if err != nil {
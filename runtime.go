@@ -381,9 +381,47 @@ func (runtime *Runtime) Create(config *Config, name string) (*Container, []strin
 		return nil, nil, fmt.Errorf("No command specified")
 	}
 
-	sysInitPath := utils.DockerInitPath()
+	// Resolve the DNS configuration before anything is registered, so that
+	// an error doesn't leave a name or a container directory behind
+	dnsSearch, err := getDnsSearch(config)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	resolvConf, err := utils.GetResolvConf()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if len(config.Dns) == 0 && len(runtime.config.Dns) == 0 && utils.CheckLocalDns(resolvConf) {
+		//"WARNING: Docker detected local DNS server on resolv.conf. Using default external servers: %v", defaultDns
+		runtime.config.Dns = defaultDns
+	}
+
+	var dns []string
+	customResolvConf := len(config.Dns) > 0 || len(runtime.config.Dns) > 0 || len(config.DnsSearch) > 0 || len(dnsSearch) > 0
+	if customResolvConf {
+		if len(config.Dns) > 0 {
+			dns = config.Dns
+		} else if len(runtime.config.Dns) > 0 {
+			dns = runtime.config.Dns
+		} else {
+			dns = utils.GetNameservers(resolvConf)
+		}
+		if len(dns) == 0 {
+			return nil, nil, fmt.Errorf("No nameserver found in the host's resolv.conf, use -dns to set one")
+		}
+	}
+
+	// The working directory may be inherited from an image built before
+	// relative ones were rejected; anchor it at the rootfs
+	if config.WorkingDir != "" {
+		config.WorkingDir = path.Join("/", config.WorkingDir)
+	}
+
+	sysInitPath := utils.DockerInitPath(runtime.config.InitPath)
 	if sysInitPath == "" {
-		return nil, nil, fmt.Errorf("Could not locate dockerinit: This usually means docker was built incorrectly. See http://docs.docker.io/en/latest/contributing/devenvironment for official build instructions.")
+		return nil, nil, fmt.Errorf("Could not locate dockerinit: This usually means docker was built incorrectly, or that its binary was replaced while running (use -initpath to point to a dockerinit explicitly). See http://docs.docker.io/en/latest/contributing/devenvironment for official build instructions.")
 	}
 
 	// Generate id
@@ -446,24 +484,8 @@ func (runtime *Runtime) Create(config *Config, name string) (*Container, []strin
 		return nil, nil, err
 	}
 
-	resolvConf, err := utils.GetResolvConf()
-	if err != nil {
-		return nil, nil, err
-	}
-
-	if len(config.Dns) == 0 && len(runtime.config.Dns) == 0 && utils.CheckLocalDns(resolvConf) {
-		//"WARNING: Docker detected local DNS server on resolv.conf. Using default external servers: %v", defaultDns
-		runtime.config.Dns = defaultDns
-	}
-
-	// If custom dns exists, then create a resolv.conf for the container
-	if len(config.Dns) > 0 || len(runtime.config.Dns) > 0 {
-		var dns []string
-		if len(config.Dns) > 0 {
-			dns = config.Dns
-		} else {
-			dns = runtime.config.Dns
-		}
+	// If custom dns or search domains exist, then create a resolv.conf for the container
+	if customResolvConf {
 		container.ResolvConfPath = path.Join(container.root, "resolv.conf")
 		f, err := os.Create(container.ResolvConfPath)
 		if err != nil {
@@ -475,6 +497,11 @@ func (runtime *Runtime) Create(config *Config, name string) (*Container, []strin
 				return nil, nil, err
 			}
 		}
+		if len(dnsSearch) > 0 {
+			if _, err := f.Write([]byte("search " + strings.Join(dnsSearch, " ") + "\n")); err != nil {
+				return nil, nil, err
+			}
+		}
 	} else {
 		container.ResolvConfPath = "/etc/resolv.conf"
 	}
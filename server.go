@@ -1045,6 +1045,9 @@ func (srv *Server) ContainerCreate(job *engine.Job) string {
 	if err := job.ExportEnv(&config); err != nil {
 		return err.Error()
 	}
+	if err := validateWorkingDir(config.WorkingDir); err != nil {
+		return err.Error()
+	}
 	if config.Memory != 0 && config.Memory < 524288 {
 		return "Minimum memory limit allowed is 512k"
 	}
@@ -1415,6 +1418,18 @@ func (srv *Server) ContainerStart(job *engine.Job) string {
 				return fmt.Sprintf("Invalid bind mount '%s' : source doesn't exist", bind)
 			}
 		}
+		if err := validateCapabilities(hostConfig.Privileged, hostConfig.CapAdd, hostConfig.CapDrop); err != nil {
+			return err.Error()
+		}
+		if err := validateIPEnv(hostConfig.IPEnv); err != nil {
+			return err.Error()
+		}
+		if err := validateEnvDuplicates(hostConfig.EnvDuplicates); err != nil {
+			return err.Error()
+		}
+		if err := validateMissingUser(hostConfig.MissingUser); err != nil {
+			return err.Error()
+		}
 		// Register any links from the host config before starting the container
 		// FIXME: we could just pass the container here, no need to lookup by name again.
 		if err := srv.RegisterLinks(name, &hostConfig); err != nil {
@@ -1,6 +1,7 @@
 package sysinit
 
 import (
+	"bytes"
 	"encoding/json"
 	"flag"
 	"fmt"
@@ -11,11 +12,141 @@ import (
 	"net"
 	"os"
 	"os/exec"
+	"path"
 	"strconv"
 	"strings"
 	"syscall"
 )
 
+// Arguments handed to dockerinit, either on the command line or through
+// a JSON file passed with -config
+type DockerInitArgs struct {
+	User        string
+	MissingUser string // One of the MissingUser* policies, MissingUserError if empty
+	Gateway     string
+	WorkDir     string
+	Env         []string
+	Args        []string
+}
+
+// What to do when the user to run as has no entry in /etc/passwd
+const (
+	MissingUserError    = "error"           // refuse to run, this is the default
+	MissingUserFallback = "create-fallback" // run a numeric uid as is, a name is still an error
+)
+
+// Load the init arguments from a JSON file. Unknown keys are refused, so
+// that a misspelled one doesn't go unnoticed.
+func loadConfig(file string) (*DockerInitArgs, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	args := &DockerInitArgs{}
+	dec := json.NewDecoder(bytes.NewReader(data))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(args); err != nil {
+		switch jerr := err.(type) {
+		case *json.SyntaxError:
+			return nil, fmt.Errorf("Invalid config %s (line %d): %s", file, lineAt(data, jerr.Offset), jerr)
+		case *json.UnmarshalTypeError:
+			return nil, fmt.Errorf("Invalid config %s (line %d): %s", file, lineAt(data, jerr.Offset), jerr)
+		}
+		return nil, fmt.Errorf("Invalid config %s: %s", file, err)
+	}
+	if dec.More() {
+		return nil, fmt.Errorf("Invalid config %s (line %d): unexpected data after the top-level object", file, lineAt(data, dec.InputOffset()))
+	}
+	return args, nil
+}
+
+// Return the line of data holding the given byte offset
+func lineAt(data []byte, offset int64) int {
+	if offset > int64(len(data)) {
+		offset = int64(len(data))
+	}
+	return bytes.Count(data[:offset], []byte("\n")) + 1
+}
+
+// Parse the command line. Values loaded from -config are used as defaults,
+// flags given explicitly on the command line take precedence over them.
+func parseArgs(arguments []string) (*DockerInitArgs, error) {
+	cmd := flag.NewFlagSet("dockerinit", flag.ContinueOnError)
+	configPath := cmd.String("config", "", "path to a JSON file holding the init arguments")
+	u := cmd.String("u", "", "username or uid")
+	missingUser := cmd.String("missing-user", "", "what to do when the user has no passwd entry: error (default) or create-fallback")
+	gw := cmd.String("g", "", "gateway address")
+	workdir := cmd.String("w", "", "workdir")
+	argsFile := cmd.String("args-file", "", "path to a file holding the NUL-delimited command and arguments")
+	var env utils.ListOpts
+	cmd.Var(&env, "e", "set an environment variable (KEY=VALUE), overrides the container environment")
+
+	if err := cmd.Parse(arguments); err != nil {
+		return nil, err
+	}
+	for _, kv := range env {
+		if !strings.Contains(kv, "=") {
+			return nil, fmt.Errorf("Invalid environment variable %q: expected KEY=VALUE", kv)
+		}
+	}
+
+	args := &DockerInitArgs{}
+	if *configPath != "" {
+		var err error
+		if args, err = loadConfig(*configPath); err != nil {
+			return nil, err
+		}
+	}
+	cmd.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "u":
+			args.User = *u
+		case "missing-user":
+			args.MissingUser = *missingUser
+		case "g":
+			args.Gateway = *gw
+		case "w":
+			args.WorkDir = *workdir
+		case "e":
+			args.Env = env
+		}
+	})
+	switch args.MissingUser {
+	case "":
+		args.MissingUser = MissingUserError
+	case MissingUserError, MissingUserFallback:
+	default:
+		return nil, fmt.Errorf("Invalid missing user policy %q: expected error or create-fallback", args.MissingUser)
+	}
+	if *argsFile != "" {
+		if cmd.NArg() > 0 {
+			return nil, fmt.Errorf("Conflicting options: -args-file and a command line")
+		}
+		var err error
+		if args.Args, err = loadArgs(*argsFile); err != nil {
+			return nil, err
+		}
+	} else if cmd.NArg() > 0 {
+		args.Args = cmd.Args()
+	}
+	return args, nil
+}
+
+// Load NUL-delimited arguments from a file. This avoids any quoting issue
+// with arguments containing spaces, newlines or other special characters.
+func loadArgs(file string) ([]string, error) {
+	data, err := ioutil.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// A trailing NUL terminates the last argument
+	data = bytes.TrimSuffix(data, []byte{0})
+	if len(data) == 0 {
+		return nil, fmt.Errorf("Invalid arguments file %s: no command specified", file)
+	}
+	return strings.Split(string(data), "\x00"), nil
+}
+
 // Setup networking
 func setupNetworking(gw string) {
 	if gw == "" {
@@ -38,28 +169,22 @@ func setupWorkingDirectory(workdir string) {
 	if workdir == "" {
 		return
 	}
-	if err := syscall.Chdir(workdir); err != nil {
+	if !path.IsAbs(workdir) {
+		log.Fatalf("Unable to change dir to %v: the working directory must be an absolute path", workdir)
+	}
+	if err := syscall.Chdir(path.Clean(workdir)); err != nil {
 		log.Fatalf("Unable to change dir to %v: %v", workdir, err)
 	}
 }
 
 // Takes care of dropping privileges to the desired user
-func changeUser(u string) {
+func changeUser(u, missingUser string) {
 	if u == "" {
 		return
 	}
-	userent, err := utils.UserLookup(u)
+	uid, gid, err := resolveUser(u, missingUser)
 	if err != nil {
-		log.Fatalf("Unable to find user %v: %v", u, err)
-	}
-
-	uid, err := strconv.Atoi(userent.Uid)
-	if err != nil {
-		log.Fatalf("Invalid uid: %v", userent.Uid)
-	}
-	gid, err := strconv.Atoi(userent.Gid)
-	if err != nil {
-		log.Fatalf("Invalid gid: %v", userent.Gid)
+		log.Fatal(err)
 	}
 
 	if err := syscall.Setgid(gid); err != nil {
@@ -70,37 +195,96 @@ func changeUser(u string) {
 	}
 }
 
-// Clear environment pollution introduced by lxc-start
-func cleanupEnv() {
+// Resolve a user name, uid or uid:gid to numeric ids. The user must exist in
+// /etc/passwd, unless missingUser is MissingUserFallback: a numeric uid
+// without a passwd entry is then used as is, with the same gid unless one is
+// given, so that it never ends up in the root group by accident. A name that
+// can't be found is always an error, it is usually a typo or the wrong image.
+func resolveUser(u, missingUser string) (int, int, error) {
+	name, group := u, ""
+	if parts := strings.SplitN(u, ":", 2); len(parts) == 2 {
+		name, group = parts[0], parts[1]
+	}
+
+	var uid, gid int
+	userent, err := utils.UserLookup(name)
+	if err == nil {
+		if uid, err = strconv.Atoi(userent.Uid); err != nil {
+			return 0, 0, fmt.Errorf("Invalid uid: %v", userent.Uid)
+		}
+		if gid, err = strconv.Atoi(userent.Gid); err != nil {
+			return 0, 0, fmt.Errorf("Invalid gid: %v", userent.Gid)
+		}
+	} else if n, cerr := strconv.Atoi(name); cerr != nil || n < 0 {
+		return 0, 0, fmt.Errorf("Unable to find user %v: User not found in /etc/passwd", name)
+	} else if missingUser != MissingUserFallback {
+		return 0, 0, fmt.Errorf("Unable to find user %v: User not found in /etc/passwd (use the create-fallback policy to run a numeric uid without an entry)", name)
+	} else {
+		uid, gid = n, n
+	}
+
+	if group != "" {
+		if gid, err = strconv.Atoi(group); err != nil || gid < 0 {
+			return 0, 0, fmt.Errorf("Invalid gid: %v", group)
+		}
+	}
+	return uid, gid, nil
+}
+
+// Clear environment pollution introduced by lxc-start, and load the
+// container environment from envFile. Variables passed to dockerinit with
+// -e take precedence over the ones from the container config.
+func cleanupEnv(envFile string, env []string) error {
 	os.Clearenv()
 	var lines []string
-	content, err := ioutil.ReadFile("/.dockerenv")
+	content, err := ioutil.ReadFile(envFile)
 	if err != nil {
-		log.Fatalf("Unable to load environment variables: %v", err)
+		return fmt.Errorf("Unable to load environment variables: %v", err)
 	}
 	err = json.Unmarshal(content, &lines)
 	if err != nil {
-		log.Fatalf("Unable to unmarshal environment variables: %v", err)
+		return fmt.Errorf("Unable to unmarshal environment variables: %v", err)
 	}
-	for _, kv := range lines {
+	for _, kv := range append(lines, env...) {
 		parts := strings.SplitN(kv, "=", 2)
 		if len(parts) == 1 {
 			parts = append(parts, "")
 		}
 		os.Setenv(parts[0], parts[1])
 	}
+	return nil
 }
 
-func executeProgram(name string, args []string) {
-	path, err := exec.LookPath(name)
+func executeProgram(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("no command specified")
+	}
+	path, err := exec.LookPath(args[0])
 	if err != nil {
-		log.Printf("Unable to locate %v", name)
+		// LookPath already refuses a path to a file without the exec bit
+		if e, ok := err.(*exec.Error); ok && os.IsPermission(e.Err) {
+			return execError(args[0], syscall.EACCES)
+		}
+		log.Printf("Unable to locate %v", args[0])
 		os.Exit(127)
 	}
 
-	if err := syscall.Exec(path, args, os.Environ()); err != nil {
-		panic(err)
+	// On success, Exec does not return
+	return execError(path, syscall.Exec(path, args, os.Environ()))
+}
+
+// Turn the most common exec failures into an actionable message
+func execError(path string, err error) error {
+	switch err {
+	case syscall.EACCES:
+		if fi, serr := os.Stat(path); serr == nil {
+			return fmt.Errorf("exec %s: permission denied: %s is not executable (mode %s)", path, path, fi.Mode())
+		}
+		return fmt.Errorf("exec %s: permission denied: %s is not executable", path, path)
+	case syscall.ENOEXEC:
+		return fmt.Errorf("exec %s: exec format error: %s is neither a binary for this architecture nor a script starting with #!", path, path)
 	}
+	return fmt.Errorf("exec %s: %v", path, err)
 }
 
 // Sys Init code
@@ -111,15 +295,16 @@ func SysInit() {
 		fmt.Println("You should not invoke dockerinit manually")
 		os.Exit(1)
 	}
-	var u = flag.String("u", "", "username or uid")
-	var gw = flag.String("g", "", "gateway address")
-	var workdir = flag.String("w", "", "workdir")
-
-	flag.Parse()
+	args, err := parseArgs(os.Args[1:])
+	if err != nil {
+		log.Fatal(err)
+	}
 
-	cleanupEnv()
-	setupNetworking(*gw)
-	setupWorkingDirectory(*workdir)
-	changeUser(*u)
-	executeProgram(flag.Arg(0), flag.Args())
+	if err := cleanupEnv("/.dockerenv", args.Env); err != nil {
+		log.Fatal(err)
+	}
+	setupNetworking(args.Gateway)
+	setupWorkingDirectory(args.WorkDir)
+	changeUser(args.User, args.MissingUser)
+	log.Fatal(executeProgram(args.Args))
 }
@@ -0,0 +1,265 @@
+package sysinit
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, content string) string {
+	f, err := ioutil.TempFile("", "dockerinit-test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err := f.WriteString(content); err != nil {
+		t.Fatal(err)
+	}
+	return f.Name()
+}
+
+func TestParseArgsConfigFile(t *testing.T) {
+	config := writeTempFile(t, `{"User": "daemon", "Gateway": "10.0.0.1", "WorkDir": "/tmp", "Args": ["/bin/true"]}`)
+	defer os.Remove(config)
+
+	args, err := parseArgs([]string{"-config", config, "-u", "root", "--", "/bin/echo", "hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.User != "root" {
+		t.Errorf("Expected user root (from the flag), got %s", args.User)
+	}
+	if args.Gateway != "10.0.0.1" {
+		t.Errorf("Expected gateway 10.0.0.1 (from the config), got %s", args.Gateway)
+	}
+	if args.WorkDir != "/tmp" {
+		t.Errorf("Expected workdir /tmp (from the config), got %s", args.WorkDir)
+	}
+	if len(args.Args) != 2 || args.Args[0] != "/bin/echo" || args.Args[1] != "hello" {
+		t.Errorf("Expected args [/bin/echo hello], got %v", args.Args)
+	}
+}
+
+func TestParseArgsInvalidConfigFile(t *testing.T) {
+	config := writeTempFile(t, "{\n\"User\": \"daemon\",\n\"Args\": [,]\n}")
+	defer os.Remove(config)
+
+	_, err := parseArgs([]string{"-config", config})
+	if err == nil {
+		t.Fatal("Expected an error for an invalid config file")
+	}
+	if !strings.Contains(err.Error(), "line 3") {
+		t.Fatalf("Expected the error to point at line 3, got %s", err)
+	}
+}
+
+func TestParseArgsConfigFileValidation(t *testing.T) {
+	for content, expected := range map[string]string{
+		"{\n\"User\": \"daemon\",\n\"Gatway\": \"10.0.0.1\"\n}": `unknown field "Gatway"`,
+		"{\n\"User\": \"daemon\",\n\"Args\": \"/bin/true\"\n}":  "(line 3)",
+		"{\"User\": \"daemon\"}\n{\"User\": \"root\"}":          "(line 2)",
+	} {
+		config := writeTempFile(t, content)
+		defer os.Remove(config)
+
+		_, err := parseArgs([]string{"-config", config})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Fatalf("Expected an error containing %q for %q, got %v", expected, content, err)
+		}
+	}
+}
+
+func TestParseArgsEnv(t *testing.T) {
+	args, err := parseArgs([]string{"-e", "FOO=bar", "-e", "EMPTY=", "--", "/bin/env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(args.Env) != 2 || args.Env[0] != "FOO=bar" || args.Env[1] != "EMPTY=" {
+		t.Fatalf("Expected env [FOO=bar EMPTY=], got %v", args.Env)
+	}
+
+	if _, err := parseArgs([]string{"-e", "FOO", "--", "/bin/env"}); err == nil {
+		t.Fatal("Expected an error for an environment variable without '='")
+	}
+}
+
+func TestCleanupEnv(t *testing.T) {
+	saved := os.Environ()
+	defer func() {
+		os.Clearenv()
+		for _, kv := range saved {
+			parts := strings.SplitN(kv, "=", 2)
+			os.Setenv(parts[0], parts[1])
+		}
+	}()
+
+	envFile := writeTempFile(t, `["HOME=/", "FOO=from-file", "BAR=from-file"]`)
+	defer os.Remove(envFile)
+
+	args, err := parseArgs([]string{"-e", "FOO=from-flag", "-e", "NEW=from-flag", "/bin/env"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := cleanupEnv(envFile, args.Env); err != nil {
+		t.Fatal(err)
+	}
+
+	// -e overrides the container environment and adds to it
+	env := os.Environ()
+	sort.Strings(env)
+	expected := "BAR=from-file FOO=from-flag HOME=/ NEW=from-flag"
+	if strings.Join(env, " ") != expected {
+		t.Fatalf("Expected environment %s, got %v", expected, env)
+	}
+
+	if err := cleanupEnv(envFile+".missing", nil); err == nil {
+		t.Fatal("Expected an error for a missing environment file")
+	}
+}
+
+func TestExecError(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestExecError")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "app")
+	for _, c := range []struct {
+		content  string
+		mode     os.FileMode
+		expected string
+	}{
+		{"#!/bin/sh\n", 0644, "exec " + path + ": permission denied: " + path + " is not executable (mode -rw-r--r--)"},
+		{"echo no shebang\n", 0755, "exec " + path + ": exec format error: " + path + " is neither a binary for this architecture nor a script starting with #!"},
+	} {
+		os.Remove(path)
+		if err := ioutil.WriteFile(path, []byte(c.content), c.mode); err != nil {
+			t.Fatal(err)
+		}
+		// executeProgram only returns when the exec fails, which is what we want here
+		err := executeProgram([]string{path})
+		if err == nil || err.Error() != c.expected {
+			t.Fatalf("Expected %q, got %v", c.expected, err)
+		}
+	}
+}
+
+func TestParseArgsFile(t *testing.T) {
+	file := writeTempFile(t, "/bin/echo\x00hello world\x00multi\nline\x00")
+	defer os.Remove(file)
+
+	args, err := parseArgs([]string{"-args-file", file})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := []string{"/bin/echo", "hello world", "multi\nline"}
+	if len(args.Args) != len(expected) {
+		t.Fatalf("Expected args %q, got %q", expected, args.Args)
+	}
+	for i := range expected {
+		if args.Args[i] != expected[i] {
+			t.Fatalf("Expected args %q, got %q", expected, args.Args)
+		}
+	}
+
+	if _, err := parseArgs([]string{"-args-file", file, "--", "/bin/true"}); err == nil {
+		t.Fatal("Expected an error when both -args-file and a command are given")
+	}
+
+	empty := writeTempFile(t, "")
+	defer os.Remove(empty)
+	if _, err := parseArgs([]string{"-args-file", empty}); err == nil {
+		t.Fatal("Expected an error for an empty arguments file")
+	}
+}
+
+func TestResolveUser(t *testing.T) {
+	for _, policy := range []string{MissingUserError, MissingUserFallback} {
+		uid, gid, err := resolveUser("root", policy)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if uid != 0 || gid != 0 {
+			t.Fatalf("Expected root to be 0:0, got %d:%d", uid, gid)
+		}
+		if uid, gid, err = resolveUser("0:4242", policy); err != nil {
+			t.Fatal(err)
+		}
+		if uid != 0 || gid != 4242 {
+			t.Fatalf("Expected 0:4242, got %d:%d", uid, gid)
+		}
+
+		// Unknown names are always an error
+		if _, _, err := resolveUser("docker-nonexistent-user", policy); err == nil {
+			t.Fatal("Expected an error for a user missing from /etc/passwd")
+		}
+		if _, _, err := resolveUser("root:staff", policy); err == nil {
+			t.Fatal("Expected an error for a non numeric gid")
+		}
+	}
+
+	// By default, numeric ids need an entry in /etc/passwd too
+	if _, _, err := resolveUser("54321", MissingUserError); err == nil {
+		t.Fatal("Expected an error for a uid missing from /etc/passwd")
+	}
+
+	// but not with the create-fallback policy
+	uid, gid, err := resolveUser("54321", MissingUserFallback)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if uid != 54321 || gid != 54321 {
+		t.Fatalf("Expected 54321:54321, got %d:%d", uid, gid)
+	}
+	if uid, gid, err = resolveUser("54321:4242", MissingUserFallback); err != nil {
+		t.Fatal(err)
+	}
+	if uid != 54321 || gid != 4242 {
+		t.Fatalf("Expected 54321:4242, got %d:%d", uid, gid)
+	}
+}
+
+func TestParseArgsMissingUser(t *testing.T) {
+	args, err := parseArgs([]string{"-u", "1000", "/bin/true"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if args.MissingUser != MissingUserError {
+		t.Fatalf("Expected the %s policy by default, got %s", MissingUserError, args.MissingUser)
+	}
+	if args, err = parseArgs([]string{"-missing-user", "create-fallback", "/bin/true"}); err != nil {
+		t.Fatal(err)
+	}
+	if args.MissingUser != MissingUserFallback {
+		t.Fatalf("Expected the %s policy, got %s", MissingUserFallback, args.MissingUser)
+	}
+	if _, err := parseArgs([]string{"-missing-user", "random", "/bin/true"}); err == nil {
+		t.Fatal("Expected an error for an unknown policy")
+	}
+}
+
+func TestExecuteProgramNoCommand(t *testing.T) {
+	for _, args := range [][]string{nil, {}} {
+		err := executeProgram(args)
+		if err == nil || err.Error() != "no command specified" {
+			t.Fatalf("Expected 'no command specified', got %v", err)
+		}
+	}
+}
+
+func TestExecuteProgramNotExecutable(t *testing.T) {
+	file := writeTempFile(t, "#!/bin/sh\n")
+	defer os.Remove(file)
+	if err := os.Chmod(file, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := executeProgram([]string{file})
+	if err == nil || !strings.HasPrefix(err.Error(), "exec "+file+": permission denied") {
+		t.Fatalf("Expected a permission denied error, got %v", err)
+	}
+}
@@ -5,6 +5,7 @@ import (
 	"github.com/dotcloud/docker/namesgenerator"
 	"github.com/dotcloud/docker/utils"
 	"io/ioutil"
+	"path"
 	"strconv"
 	"strings"
 )
@@ -24,11 +25,13 @@ func CompareConfig(a, b *Config) bool {
 		a.CpuShares != b.CpuShares ||
 		a.OpenStdin != b.OpenStdin ||
 		a.Tty != b.Tty ||
+		a.DnsSearchDomainname != b.DnsSearchDomainname ||
 		a.VolumesFrom != b.VolumesFrom {
 		return false
 	}
 	if len(a.Cmd) != len(b.Cmd) ||
 		len(a.Dns) != len(b.Dns) ||
+		len(a.DnsSearch) != len(b.DnsSearch) ||
 		len(a.Env) != len(b.Env) ||
 		len(a.PortSpecs) != len(b.PortSpecs) ||
 		len(a.ExposedPorts) != len(b.ExposedPorts) ||
@@ -47,6 +50,11 @@ func CompareConfig(a, b *Config) bool {
 			return false
 		}
 	}
+	for i := 0; i < len(a.DnsSearch); i++ {
+		if a.DnsSearch[i] != b.DnsSearch[i] {
+			return false
+		}
+	}
 	for i := 0; i < len(a.Env); i++ {
 		if a.Env[i] != b.Env[i] {
 			return false
@@ -167,6 +175,9 @@ func MergeConfig(userConf, imageConf *Config) error {
 		//duplicates aren't an issue here
 		userConf.Dns = append(userConf.Dns, imageConf.Dns...)
 	}
+	if userConf.DnsSearch == nil || len(userConf.DnsSearch) == 0 {
+		userConf.DnsSearch = imageConf.DnsSearch
+	}
 	if userConf.Entrypoint == nil || len(userConf.Entrypoint) == 0 {
 		userConf.Entrypoint = imageConf.Entrypoint
 	}
@@ -186,6 +197,23 @@ func MergeConfig(userConf, imageConf *Config) error {
 	return nil
 }
 
+// validateWorkingDir checks a user supplied working directory: it must be
+// absolute and must not contain ".." elements.
+func validateWorkingDir(workingDir string) error {
+	if workingDir == "" {
+		return nil
+	}
+	if !path.IsAbs(workingDir) {
+		return ErrInvalidWorikingDirectory
+	}
+	for _, elem := range strings.Split(workingDir, "/") {
+		if elem == ".." {
+			return ErrInvalidWorikingDirectory
+		}
+	}
+	return nil
+}
+
 func parseLxcConfOpts(opts utils.ListOpts) ([]KeyValuePair, error) {
 	out := make([]KeyValuePair, len(opts))
 	for i, o := range opts {
@@ -206,6 +234,166 @@ func parseLxcOpt(opt string) (string, string, error) {
 	return strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]), nil
 }
 
+var (
+	// Every capability known to lxc.cap.drop
+	allCapabilities = []string{
+		"chown", "dac_override", "dac_read_search", "fowner", "fsetid", "kill",
+		"setgid", "setuid", "setpcap", "linux_immutable", "net_bind_service",
+		"net_broadcast", "net_admin", "net_raw", "ipc_lock", "ipc_owner",
+		"sys_module", "sys_rawio", "sys_chroot", "sys_ptrace", "sys_pacct",
+		"sys_admin", "sys_boot", "sys_nice", "sys_resource", "sys_time",
+		"sys_tty_config", "mknod", "lease", "audit_write", "audit_control",
+		"setfcap", "mac_override", "mac_admin", "syslog", "wake_alarm",
+		"block_suspend",
+	}
+	// Capabilities dropped from unprivileged containers by default
+	defaultCapDrop = []string{
+		"audit_control", "audit_write", "mac_admin", "mac_override", "mknod",
+		"setpcap", "sys_admin", "sys_module", "sys_nice", "sys_pacct",
+		"sys_rawio", "sys_resource", "sys_time", "sys_tty_config",
+	}
+)
+
+// Compute the DNS search domains of a container, in order. A single "."
+// means no search domains at all, so it can't be combined with others.
+func getDnsSearch(config *Config) ([]string, error) {
+	search := config.DnsSearch
+	if config.DnsSearchDomainname && config.Domainname != "" {
+		found := false
+		for _, domain := range search {
+			found = found || domain == config.Domainname
+		}
+		if !found {
+			search = append(append([]string{}, search...), config.Domainname)
+		}
+	}
+	for _, domain := range search {
+		if domain == "." {
+			if len(search) > 1 {
+				return nil, ErrConflictDnsSearch
+			}
+			return []string{}, nil
+		}
+	}
+	return search, nil
+}
+
+func validateIPEnv(name string) error {
+	if strings.Contains(name, "=") {
+		return fmt.Errorf("Invalid environment variable name for -ip-env: %s", name)
+	}
+	return nil
+}
+
+func validateEnvDuplicates(mode string) error {
+	switch mode {
+	case "", EnvDuplicatesLast, EnvDuplicatesFirst, EnvDuplicatesError:
+		return nil
+	}
+	return fmt.Errorf("Invalid value for -env-duplicates: %s (expected last, first or error)", mode)
+}
+
+func validateMissingUser(policy string) error {
+	switch policy {
+	case "", MissingUserError, MissingUserFallback:
+		return nil
+	}
+	return fmt.Errorf("Invalid value for -missing-user: %s (expected error or create-fallback)", policy)
+}
+
+// Resolve the variables set more than once in env according to mode (one of
+// the EnvDuplicates* modes, "last" if empty). Variables keep the position of
+// their first definition.
+func dedupEnv(env []string, mode string) ([]string, error) {
+	var (
+		keys   []string
+		values = make(map[string]string)
+	)
+	for _, kv := range env {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) == 1 {
+			parts = append(parts, "")
+		}
+		if _, exists := values[parts[0]]; exists {
+			switch mode {
+			case EnvDuplicatesFirst:
+				continue
+			case EnvDuplicatesError:
+				return nil, fmt.Errorf("Environment variable %s is set more than once", parts[0])
+			}
+		} else {
+			keys = append(keys, parts[0])
+		}
+		values[parts[0]] = parts[1]
+	}
+	result := make([]string, 0, len(keys))
+	for _, key := range keys {
+		result = append(result, key+"="+values[key])
+	}
+	return result, nil
+}
+
+// Normalize a capability name to the form used by lxc.cap.drop
+// (eg. CAP_SYS_ADMIN and SYS_ADMIN both become sys_admin)
+func parseCapability(name string) (string, error) {
+	c := strings.TrimPrefix(strings.ToLower(strings.TrimSpace(name)), "cap_")
+	for _, known := range allCapabilities {
+		if c == known {
+			return c, nil
+		}
+	}
+	return "", fmt.Errorf("Unknown capability: %s", name)
+}
+
+// Check the capabilities requested for a container. A privileged container
+// keeps all of them, so adding or dropping some makes no sense.
+func validateCapabilities(privileged bool, capAdd, capDrop []string) error {
+	if privileged && (len(capAdd) > 0 || len(capDrop) > 0) {
+		return ErrConflictPrivilegedCaps
+	}
+	_, err := getCapDrop(capAdd, capDrop)
+	return err
+}
+
+// Compute the capabilities to drop from a container: the default set,
+// plus capDrop, minus capAdd.
+func getCapDrop(capAdd, capDrop []string) ([]string, error) {
+	added := make(map[string]bool)
+	for _, name := range capAdd {
+		c, err := parseCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		added[c] = true
+	}
+	dropped := make(map[string]bool)
+	for _, name := range capDrop {
+		c, err := parseCapability(name)
+		if err != nil {
+			return nil, err
+		}
+		if added[c] {
+			return nil, fmt.Errorf("Conflicting options: capability %s is both added and dropped", c)
+		}
+		dropped[c] = true
+	}
+
+	var drop []string
+	for _, c := range defaultCapDrop {
+		if !added[c] {
+			drop = append(drop, c)
+		}
+		delete(dropped, c)
+	}
+	// Keep the order of allCapabilities for the extra ones
+	for _, c := range allCapabilities {
+		if dropped[c] {
+			drop = append(drop, c)
+		}
+	}
+	return drop, nil
+}
+
 // We will receive port specs in the format of ip:public:private/proto and these need to be
 // parsed in the internal types
 func parsePortSpecs(ports []string) (map[Port]struct{}, map[Port][]PortBinding, error) {
@@ -11,6 +11,7 @@ import (
 	"index/suffixarray"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"os"
 	"os/exec"
@@ -226,15 +227,19 @@ func Trunc(s string, maxlen int) string {
 
 // Figure out the absolute path of our own binary
 func SelfPath() string {
-	path, err := exec.LookPath(os.Args[0])
+	path, err := lookupSelfPath()
 	if err != nil {
 		panic(err)
 	}
-	path, err = filepath.Abs(path)
+	return path
+}
+
+func lookupSelfPath() (string, error) {
+	path, err := exec.LookPath(os.Args[0])
 	if err != nil {
-		panic(err)
+		return "", err
 	}
-	return path
+	return filepath.Abs(path)
 }
 
 func dockerInitSha1(target string) string {
@@ -270,18 +275,25 @@ func isValidDockerInitPath(target string, selfPath string) bool { // target and
 }
 
 // Figure out the path of our dockerinit (which may be SelfPath())
-func DockerInitPath() string {
-	selfPath := SelfPath()
-	if isValidDockerInitPath(selfPath, selfPath) {
-		// if we're valid, don't bother checking anything else
-		return selfPath
-	}
-	var possibleInits = []string{
-		filepath.Join(filepath.Dir(selfPath), "dockerinit"),
+// If it can't be found, for instance because the docker binary was deleted or
+// replaced while running, fall back to initPath when it is set.
+func DockerInitPath(initPath string) string {
+	var possibleInits []string
+	selfPath, err := lookupSelfPath()
+	if err != nil {
+		Debugf("Unable to locate the docker binary: %s", err)
+	} else {
+		if isValidDockerInitPath(selfPath, selfPath) {
+			// if we're valid, don't bother checking anything else
+			return selfPath
+		}
+		possibleInits = append(possibleInits, filepath.Join(filepath.Dir(selfPath), "dockerinit"))
+	}
+	possibleInits = append(possibleInits,
 		// "/usr/libexec includes internal binaries that are not intended to be executed directly by users or shell scripts. Applications may use a single subdirectory under /usr/libexec."
 		"/usr/libexec/docker/dockerinit",
 		"/usr/local/libexec/docker/dockerinit",
-	}
+	)
 	for _, dockerInit := range possibleInits {
 		path, err := exec.LookPath(dockerInit)
 		if err == nil {
@@ -295,6 +307,14 @@ func DockerInitPath() string {
 			}
 		}
 	}
+	if initPath != "" {
+		// The operator explicitly asked for this one: trust it as long as it is executable
+		if path, err := exec.LookPath(initPath); err == nil {
+			if path, err = filepath.Abs(path); err == nil {
+				return path
+			}
+		}
+	}
 	return ""
 }
 
@@ -718,6 +738,35 @@ func CopyDirectory(source, dest string) error {
 	return nil
 }
 
+// AtomicWriteFile writes data to filename like ioutil.WriteFile, but through a
+// temporary file renamed over the destination, so readers never see a
+// partially written file. The data is synced before the rename, so that the
+// file is not left empty after a crash.
+func AtomicWriteFile(filename string, data []byte, perm os.FileMode) error {
+	tmp := filepath.Join(filepath.Dir(filename), "."+filepath.Base(filename)+"."+RandomString()[:8])
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, perm)
+	if err != nil {
+		return err
+	}
+	n, err := f.Write(data)
+	if err == nil && n < len(data) {
+		err = io.ErrShortWrite
+	}
+	if err == nil {
+		err = f.Sync()
+	}
+	if err1 := f.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = os.Rename(tmp, filename)
+	}
+	if err != nil {
+		os.Remove(tmp)
+	}
+	return err
+}
+
 type NopFlusher struct{}
 
 func (f *NopFlusher) Flush() {}
@@ -948,16 +997,27 @@ func StripComments(input []byte, commentMarker []byte) []byte {
 // /etc/resolv.conf as CIDR blocks (e.g., "1.2.3.4/32")
 // This function's output is intended for net.ParseCIDR
 func GetNameserversAsCIDR(resolvConf []byte) []string {
+	nameservers := []string{}
+	for _, ns := range GetNameservers(resolvConf) {
+		if ip := net.ParseIP(ns); ip.To4() != nil {
+			nameservers = append(nameservers, ns+"/32")
+		}
+	}
+	return nameservers
+}
+
+// GetNameservers returns the IPv4 and IPv6 nameservers listed in the given
+// resolv.conf
+func GetNameservers(resolvConf []byte) []string {
 	var parsedResolvConf = StripComments(resolvConf, []byte("#"))
 	nameservers := []string{}
-	re := regexp.MustCompile(`^\s*nameserver\s*(([0-9]+\.){3}([0-9]+))\s*$`)
+	re := regexp.MustCompile(`^\s*nameserver\s*([0-9A-Fa-f.:]+)\s*$`)
 	for _, line := range bytes.Split(parsedResolvConf, []byte("\n")) {
 		var ns = re.FindSubmatch(line)
-		if len(ns) > 0 {
-			nameservers = append(nameservers, string(ns[1])+"/32")
+		if len(ns) > 0 && net.ParseIP(string(ns[1])) != nil {
+			nameservers = append(nameservers, string(ns[1]))
 		}
 	}
-
 	return nameservers
 }
 
@@ -2,10 +2,15 @@ package utils
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
+	"fmt"
 	"io"
 	"io/ioutil"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"testing"
 )
 
@@ -494,6 +499,8 @@ nameserver 1.2.3.4
 #nameserver 4.3.2.1`: {"1.2.3.4/32"},
 		`search example.com
 nameserver 1.2.3.4 # not 4.3.2.1`: {"1.2.3.4/32"},
+		`nameserver 2001:4860:4860::8888
+nameserver 1.2.3.4`: {"1.2.3.4/32"},
 	} {
 		test := GetNameserversAsCIDR([]byte(resolv))
 		if !StrSlicesEqual(test, result) {
@@ -502,6 +509,23 @@ nameserver 1.2.3.4 # not 4.3.2.1`: {"1.2.3.4/32"},
 	}
 }
 
+func TestGetNameservers(t *testing.T) {
+	for resolv, result := range map[string][]string{`
+nameserver 1.2.3.4
+nameserver 2001:4860:4860::8888
+search example.com`: {"1.2.3.4", "2001:4860:4860::8888"},
+		`nameserver fe80::1 # link local`: {"fe80::1"},
+		`nameserver ::1
+nameserver not.an.ip.address`: {"::1"},
+		`search example.com`: {},
+	} {
+		test := GetNameservers([]byte(resolv))
+		if !StrSlicesEqual(test, result) {
+			t.Fatalf("Wrong nameserver string {%s} should be %v. Input: %s", test, result, resolv)
+		}
+	}
+}
+
 func StrSlicesEqual(a, b []string) bool {
 	if len(a) != len(b) {
 		return false
@@ -515,3 +539,86 @@ func StrSlicesEqual(a, b []string) bool {
 
 	return true
 }
+
+func TestAtomicWriteFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestAtomicWriteFile")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	filename := filepath.Join(dir, "config.json")
+	if err := AtomicWriteFile(filename, []byte(`{"ID": "initial"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	errs := make(chan error, 100)
+	for i := 0; i < 10; i++ {
+		wg.Add(2)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				data, _ := json.Marshal(map[string]string{"ID": fmt.Sprintf("%d-%d", i, j), "Padding": strings.Repeat("x", 4096*i)})
+				if err := AtomicWriteFile(filename, data, 0600); err != nil {
+					errs <- err
+					return
+				}
+			}
+		}(i)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				data, err := ioutil.ReadFile(filename)
+				if err != nil {
+					errs <- err
+					return
+				}
+				var v map[string]string
+				if err := json.Unmarshal(data, &v); err != nil {
+					errs <- fmt.Errorf("Malformed content %q: %s", data, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected only %s to be left, found %d files", filename, len(files))
+	}
+	if mode := files[0].Mode().Perm(); mode != 0600 {
+		t.Fatalf("Expected mode 0600, got %o", mode)
+	}
+}
+
+func TestDockerInitPathFallback(t *testing.T) {
+	dir, err := ioutil.TempDir("", "TestDockerInitPathFallback")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// The test binary is not a valid dockerinit, so only the explicit path can be used
+	if path := DockerInitPath(""); path != "" {
+		t.Fatalf("Expected no dockerinit to be found, got %s", path)
+	}
+	initPath := filepath.Join(dir, "dockerinit")
+	if path := DockerInitPath(initPath); path != "" {
+		t.Fatalf("Expected a missing dockerinit to be ignored, got %s", path)
+	}
+	if err := ioutil.WriteFile(initPath, []byte("#!/bin/sh\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if path := DockerInitPath(initPath); path != initPath {
+		t.Fatalf("Expected %s, got %s", initPath, path)
+	}
+}